@@ -15,10 +15,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	dcontext "github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -31,6 +33,25 @@ import (
 const (
 	driverName             = "ccd"
 	contentTypeOctetStream = "application/offset+octet-stream"
+
+	// defaultTokenTTL is how long a cached bucket access token is reused for
+	// before it is refreshed, kept a little shorter than CCD's own token
+	// lifetime so we never hand out a token that's about to expire.
+	defaultTokenTTL = 55 * time.Minute
+
+	// defaultURLForExpiry is how long a signed content URL returned from
+	// URLFor is valid for when the caller does not supply an "expiry" option.
+	defaultURLForExpiry = 20 * time.Minute
+
+	// defaultMaxConcurrentDeletes is the number of entries Delete will remove
+	// concurrently.
+	defaultMaxConcurrentDeletes = 8
+
+	// defaultListPageSize is the number of entries requested per page when
+	// listing a bucket. CCD's GC-driven listings can run to tens of
+	// thousands of entries, so this is set well above CCD's own default of 1
+	// to avoid a round-trip per entry.
+	defaultListPageSize = 1000
 )
 
 var baseURLs = map[string]string{
@@ -50,9 +71,32 @@ func (factory *ccdDriverFactory) Create(parameters map[string]interface{}) (stor
 }
 
 type driver struct {
-	client  v1.ClientWithResponsesInterface
-	baseURL string
-	apiKey  string
+	client     v1.ClientWithResponsesInterface
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+
+	// chunkSize is the amount of data buffered by a Writer before it is
+	// flushed to CCD as a single chunk.
+	chunkSize int64
+
+	// maxRetries is the number of times a chunk upload is retried before a
+	// Writer gives up.
+	maxRetries int
+
+	// sem bounds the number of uploads, across all Writers returned by this
+	// driver, that may be in flight to CCD at once.
+	sem chan struct{}
+
+	// tokens caches bucket access tokens used to sign URLFor content links.
+	tokens *tokenCache
+
+	// maxConcurrentDeletes bounds how many entries Delete removes at once.
+	maxConcurrentDeletes int
+
+	// listPageSize is the number of entries requested per page when listing
+	// a bucket.
+	listPageSize int
 }
 
 // baseEmbed allows us to hide the Base embed.
@@ -70,6 +114,29 @@ var _ storagedriver.StorageDriver = &Driver{}
 
 // New constructs a new Driver.
 func New(parameters map[string]interface{}) (*Driver, error) {
+	return NewWithClient(parameters, nil)
+}
+
+// NewWithClient constructs a new Driver using the given *http.Client for all
+// requests to CCD, instead of http.DefaultClient. This allows callers to
+// plug in tracing round-trippers, custom timeouts, mTLS, proxies, or
+// deterministic transports in tests. A nil httpClient behaves exactly like
+// New.
+//
+// A caller may also supply the client via the "httpclient" parameter, which
+// takes precedence if both are provided.
+func NewWithClient(parameters map[string]interface{}, httpClient *http.Client) (*Driver, error) {
+	if c, ok := parameters["httpclient"]; ok {
+		hc, ok := c.(*http.Client)
+		if !ok {
+			return nil, errors.New("httpclient parameter must be an *http.Client")
+		}
+		httpClient = hc
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
 	// Parameter validation.
 	apiKey, ok := parameters["apikey"]
 	if !ok || fmt.Sprint(apiKey) == "" {
@@ -85,9 +152,69 @@ func New(parameters map[string]interface{}) (*Driver, error) {
 		return nil, errors.New("invalid environment parameter provided")
 	}
 
+	chunkSize, err := intParam(parameters, "chunksize", defaultChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize < 1 {
+		return nil, errors.New("chunksize parameter must be positive")
+	}
+
+	maxRetries, err := intParam(parameters, "maxretries", defaultMaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if maxRetries < 0 {
+		return nil, errors.New("maxretries parameter must not be negative")
+	}
+
+	maxConcurrent, err := intParam(parameters, "maxconcurrent", defaultMaxConcurrent)
+	if err != nil {
+		return nil, err
+	}
+	if maxConcurrent < 1 {
+		return nil, errors.New("maxconcurrent parameter must be positive")
+	}
+
+	tokenTTL := defaultTokenTTL
+	if v, ok := parameters["ccdtokenttl"]; ok {
+		switch v := v.(type) {
+		case time.Duration:
+			tokenTTL = v
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("ccdtokenttl parameter must be a duration: %w", err)
+			}
+			tokenTTL = d
+		default:
+			return nil, errors.New("invalid ccdtokenttl parameter provided")
+		}
+	}
+	if tokenTTL <= 0 {
+		return nil, errors.New("ccdtokenttl parameter must be positive")
+	}
+
+	maxConcurrentDeletes, err := intParam(parameters, "maxconcurrentdeletes", defaultMaxConcurrentDeletes)
+	if err != nil {
+		return nil, err
+	}
+	if maxConcurrentDeletes < 1 {
+		return nil, errors.New("maxconcurrentdeletes parameter must be positive")
+	}
+
+	listPageSize, err := intParam(parameters, "pagesize", defaultListPageSize)
+	if err != nil {
+		return nil, err
+	}
+	if listPageSize < 1 {
+		return nil, errors.New("pagesize parameter must be positive")
+	}
+
 	// CCD client.
 	client, err := v1.NewClientWithResponses(
 		baseURL,
+		v1.WithHTTPClient(httpClient),
 		v1.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
 			req.SetBasicAuth("", fmt.Sprint(apiKey))
 			return nil
@@ -101,15 +228,48 @@ func New(parameters map[string]interface{}) (*Driver, error) {
 		baseEmbed: baseEmbed{
 			Base: base.Base{
 				StorageDriver: &driver{
-					client:  client,
-					baseURL: baseURL,
-					apiKey:  fmt.Sprint(apiKey),
+					client:     client,
+					httpClient: httpClient,
+					baseURL:    baseURL,
+					apiKey:     fmt.Sprint(apiKey),
+					chunkSize:  int64(chunkSize),
+					maxRetries: maxRetries,
+					sem:        make(chan struct{}, maxConcurrent),
+					tokens:     newTokenCache(tokenTTL),
+
+					maxConcurrentDeletes: maxConcurrentDeletes,
+					listPageSize:         listPageSize,
 				},
 			},
 		},
 	}, nil
 }
 
+// intParam extracts an integer-valued parameter, accepting the numeric types
+// a client may have deserialized it as, or a string. It returns def if the
+// parameter was not provided.
+func intParam(parameters map[string]interface{}, name string, def int) (int, error) {
+	v, ok := parameters[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s parameter must be an integer: %w", name, err)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid %s parameter provided", name)
+	}
+}
+
 // Name returns the human-readable "name" of the driver, useful in error
 // messages and logging. By convention, this will just be the registration
 // name, but drivers may provide other information here.
@@ -150,7 +310,7 @@ func (d *driver) PutContent(ctx context.Context, path string, content []byte) er
 	// CCD uploads are a two stage process.
 
 	// First we create an "entry" to hold the content.
-	entryID, err := createOrUpdateEntry(ctx, d.client, bucketID, path, fileHash, len(content))
+	entryID, _, err := createOrUpdateEntry(ctx, d.client, bucketID, path, fileHash, len(content))
 	if err != nil {
 		return err
 	}
@@ -190,7 +350,7 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := d.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -200,45 +360,24 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 
 // Writer returns a FileWriter which will store the content written to it
 // at the location designated by "path" after the call to Commit.
+//
+// Content is streamed to CCD's tus-style upload endpoint in chunkSize
+// pieces as it is written, rather than spooled to local disk first.
 func (d *driver) Writer(ctx context.Context, dataPath string, append bool) (storagedriver.FileWriter, error) {
 	bucketID, err := bucketIDFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	rootDir := filepath.Join(os.TempDir(), "ccd")
-
-	// CCD requires the hash and length of an entry before it can be uploaded.
-	// Meaning we cannot stream bytes, in chunks, to a CCD entry unless we know
-	// the full content up front.
-	//
-	// TODO(dr): Revisit this when CCD implements streaming upload support.
-	if err = os.MkdirAll(filepath.Join(rootDir, path.Dir(dataPath)), 0777); err != nil {
-		return nil, err
-	}
-
-	f, err := os.OpenFile(filepath.Join(rootDir, dataPath), os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
-		return nil, err
-	}
+	w := newWriter(ctx, d.client, d.httpClient, d.apiKey, d.sem, bucketID, dataPath, d.chunkSize, d.maxRetries)
 
-	// Append to the end of an existing file, or just truncate.
-	var offset int64
 	if append {
-		n, err := f.Seek(0, io.SeekEnd)
-		if err != nil {
-			f.Close()
-			return nil, err
-		}
-		offset = n
-	} else {
-		if err = f.Truncate(0); err != nil {
-			f.Close()
+		if err := w.resume(); err != nil {
 			return nil, err
 		}
 	}
 
-	return newWriter(ctx, f, d.client, offset, bucketID, dataPath), nil
+	return w, nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current
@@ -273,7 +412,7 @@ func (d *driver) List(ctx context.Context, listPath string) ([]string, error) {
 		return nil, err
 	}
 
-	entries, err := getEntries(ctx, d.client, bucketID, listPath)
+	entries, err := getEntries(ctx, d.client, bucketID, listPath, d.listPageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -289,17 +428,33 @@ func (d *driver) List(ctx context.Context, listPath string) ([]string, error) {
 
 // Move moves an object stored at sourcePath to destPath, removing the
 // original object.
+//
+// CCD has no dedicated entry-clone/move API yet. Since CCD dedupes content
+// by hash, we instead create the destination entry referencing the source
+// entry's existing ContentHash/ContentSize, which skips re-uploading the
+// blob entirely.
+//
+// TODO(dr): Switch to a real clone call if/when the CCD v1 client exposes
+// one.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	// CCD has no copy or move feature. So all we can do is download, upload and
-	// delete.
-	content, err := d.GetContent(ctx, sourcePath)
+	bucketID, err := bucketIDFromContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if err = d.PutContent(ctx, destPath, content); err != nil {
+	srcEntry, err := getEntryByPath(ctx, d.client, bucketID, sourcePath)
+	if err != nil {
 		return err
 	}
+	if srcEntry.ContentHash == nil || srcEntry.ContentSize == nil {
+		return errors.New("ccd: source entry missing content hash/size")
+	}
+
+	if _, _, err := createOrUpdateEntry(
+		ctx, d.client, bucketID, destPath, *srcEntry.ContentHash, *srcEntry.ContentSize,
+	); err != nil {
+		return fmt.Errorf("server-side copy: %w", err)
+	}
 
 	return d.Delete(ctx, sourcePath)
 }
@@ -311,40 +466,129 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 		return err
 	}
 
-	entries, err := getEntries(ctx, d.client, bucketID, path)
+	entries, err := getEntries(ctx, d.client, bucketID, path, d.listPageSize)
 	if err != nil {
-		return nil
+		return err
 	}
 
+	g, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, d.maxConcurrentDeletes)
+
 	for _, e := range entries {
-		res, err := d.client.DeleteEntryWithResponse(ctx, bucketID, *e.Entryid)
-		if err != nil {
-			return err
-		} else if res.JSON500 != nil {
-			return fmt.Errorf("unexpected error: %q", *res.JSON500.Reason)
-		} else if sc := res.StatusCode(); sc != http.StatusNoContent {
-			return fmt.Errorf("unexpected response: %q", sc)
-		}
+		e := e
+
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+
+			res, err := d.client.DeleteEntryWithResponse(ctx, bucketID, *e.Entryid)
+			if err != nil {
+				return err
+			} else if res.JSON500 != nil {
+				return fmt.Errorf("unexpected error: %q", *res.JSON500.Reason)
+			} else if sc := res.StatusCode(); sc != http.StatusNoContent {
+				return fmt.Errorf("unexpected response: %q", sc)
+			}
+
+			return nil
+		})
 	}
 
-	return nil
+	return g.Wait()
 }
 
 // URLFor returns a URL which may be used to retrieve the content stored at
 // the given path, possibly using the given options.
 // May return an ErrUnsupportedMethod in certain StorageDriver
 // implementations.
+//
+// Downloading from CCD is done via a signed URL to Akamai, the CDN that
+// powers CCD. Fetching that signed URL requires a per-bucket access token,
+// which we acquire (and cache, see tokenCache) on first use. If a token
+// can't be acquired, or the signed URL itself can't be obtained, we fall
+// back to ErrUnsupportedMethod and let the caller use GetContent/Reader
+// instead.
 func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
-	// Downloading from CCD should be done via a signed URL to Akamai, the CDN
-	// that powers CCD.
-	//
-	// In order to get the signed URL you need to call the URL indicated by the
-	// ContentLink on a CCD entry. However, calling that URL requires an access
-	// token for the bucket. The Docker client will not have this access token.
-	//
-	// Ergo we mark this as unsupported and force the caller to fall back to the
-	// GetContent and Reader methods instead.
-	return "", storagedriver.ErrUnsupportedMethod{}
+	bucketID, err := bucketIDFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := getEntryByPath(ctx, d.client, bucketID, path)
+	if err != nil {
+		return "", err
+	}
+
+	expiresTime := time.Now().Add(defaultURLForExpiry)
+	if e, ok := options["expiry"]; ok {
+		if et, ok := e.(time.Time); ok {
+			expiresTime = et
+		}
+	}
+
+	token, err := d.bucketAccessToken(ctx, bucketID)
+	if err != nil {
+		dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+			"bucket": bucketID,
+			"path":   path,
+			"error":  err,
+		}).Warn("ccd: could not acquire bucket access token, URLFor unsupported")
+
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+
+	link, err := getContentLink(ctx, d.client, bucketID, *entry.Entryid, token, expiresTime)
+	if err != nil {
+		dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+			"bucket": bucketID,
+			"path":   path,
+			"error":  err,
+		}).Warn("ccd: could not acquire signed content link, URLFor unsupported")
+
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+
+	return link, nil
+}
+
+// bucketAccessToken returns a cached CCD access token for bucket, acquiring
+// and caching a new one if none is cached or the cached one has expired.
+func (d *driver) bucketAccessToken(ctx context.Context, bucket string) (string, error) {
+	return d.tokens.Get(bucket, func() (string, error) {
+		res, err := d.client.CreateBucketAccessTokenWithResponse(
+			ctx, bucket, v1.CreateBucketAccessTokenJSONRequestBody{},
+		)
+		if err != nil {
+			return "", err
+		} else if res.JSON500 != nil {
+			return "", fmt.Errorf("unexpected error: %q", *res.JSON500.Reason)
+		} else if res.JSON200 == nil {
+			return "", errors.New("empty response from CCD")
+		} else if res.JSON200.Accesstoken == nil {
+			return "", errors.New("failed to determine access token")
+		}
+
+		return *res.JSON200.Accesstoken, nil
+	})
+}
+
+// getContentLink returns a signed, time-limited CDN URL for the given entry.
+func getContentLink(ctx context.Context, client v1.ClientWithResponsesInterface, bucket, entryID, token string, expiry time.Time) (string, error) {
+	res, err := client.GetContentLinkWithResponse(ctx, bucket, entryID, &v1.GetContentLinkParams{
+		Accesstoken: utils.StringPtr(token),
+		Expiry:      utils.Int64Ptr(expiry.Unix()),
+	})
+	if err != nil {
+		return "", err
+	} else if res.JSON404 != nil {
+		return "", storagedriver.PathNotFoundError{}
+	} else if res.JSON500 != nil {
+		return "", fmt.Errorf("unexpected error: %q", *res.JSON500.Reason)
+	} else if res.JSON200 == nil || res.JSON200.Url == nil {
+		return "", errors.New("failed to determine content link")
+	}
+
+	return *res.JSON200.Url, nil
 }
 
 // Walk traverses a filesystem defined within driver, starting
@@ -353,13 +597,13 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 // to a directory, the directory will not be entered and Walk
 // will continue the traversal.  If fileInfo refers to a normal file, processing stops
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
-	panic(fmt.Sprintf("Walk: path: %q", path))
+	return storagedriver.WalkFallback(ctx, d, path, f)
 }
 
-// createOrUpdateEntry returns the CCD entry ID for the given path in the bucket.
-// An entry will be created at that path if it does not exist, or updated if
-// it does.
-func createOrUpdateEntry(ctx context.Context, client v1.ClientWithResponsesInterface, bucket, path, hash string, contentLength int) (string, error) {
+// createOrUpdateEntry returns the CCD entry ID, and its tus-style upload
+// URL, for the given path in the bucket. An entry will be created at that
+// path if it does not exist, or updated if it does.
+func createOrUpdateEntry(ctx context.Context, client v1.ClientWithResponsesInterface, bucket, path, hash string, contentLength int) (string, string, error) {
 	res, err := client.CreateOrUpdateEntryByPathWithResponse(
 		ctx,
 		bucket,
@@ -374,14 +618,19 @@ func createOrUpdateEntry(ctx context.Context, client v1.ClientWithResponsesInter
 		},
 	)
 	if err != nil {
-		return "", err
+		return "", "", err
 	} else if res.JSON200 == nil {
-		return "", errors.New("empty response from CCD")
+		return "", "", errors.New("empty response from CCD")
 	} else if res.JSON200.Entryid == nil {
-		return "", errors.New("failed to determine entry ID")
+		return "", "", errors.New("failed to determine entry ID")
 	}
 
-	return *res.JSON200.Entryid, nil
+	var uploadURL string
+	if res.JSON200.UploadUrl != nil {
+		uploadURL = *res.JSON200.UploadUrl
+	}
+
+	return *res.JSON200.Entryid, uploadURL, nil
 }
 
 // uploadContent uploads to the given content to the CCD entry ID.
@@ -444,7 +693,7 @@ func getEntryContent(ctx context.Context, client v1.ClientWithResponsesInterface
 	return res.Body, nil
 }
 
-func getEntries(ctx context.Context, client v1.ClientWithResponsesInterface, bucketID, listPath string) ([]v1.Releaseentry, error) {
+func getEntries(ctx context.Context, client v1.ClientWithResponsesInterface, bucketID, listPath string, perPage int) ([]v1.Releaseentry, error) {
 	var (
 		entries []v1.Releaseentry
 		page    = 1
@@ -455,12 +704,18 @@ func getEntries(ctx context.Context, client v1.ClientWithResponsesInterface, buc
 
 	// CCD entries are returned from a paginated API.
 	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
 		// TODO(dr): This is relying on the bucket not having a release. Might
 		// not be the right thing to do.
 		res, err := client.GetDiffEntriesWithResponse(ctx, bucketID, &v1.GetDiffEntriesParams{
 			Path:    utils.StringPtr(listPath),
 			Page:    utils.IntPtr(page),
-			PerPage: utils.IntPtr(1),
+			PerPage: utils.IntPtr(perPage),
 		})
 		if err != nil {
 			return nil, err
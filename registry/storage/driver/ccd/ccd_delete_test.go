@@ -0,0 +1,102 @@
+package ccd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	v1 "github.com/distribution/distribution/v3/registry/storage/driver/ccd/client/v1"
+)
+
+// mockDeleteClient is a v1.ClientWithResponsesInterface that serves a fixed
+// list of entries for one GetDiffEntriesWithResponse call (followed by an
+// empty page to terminate pagination), and records the peak number of
+// concurrent DeleteEntryWithResponse calls it observed.
+type mockDeleteClient struct {
+	v1.ClientWithResponsesInterface
+
+	entries []v1.Releaseentry
+	served  bool
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	deleted     int
+}
+
+func (m *mockDeleteClient) GetDiffEntriesWithResponse(ctx context.Context, bucket string, params *v1.GetDiffEntriesParams, reqEditors ...v1.RequestEditorFn) (*v1.GetDiffEntriesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.served {
+		empty := []v1.Releaseentry{}
+		return &v1.GetDiffEntriesResponse{JSON200: &empty}, nil
+	}
+	m.served = true
+
+	entries := m.entries
+	return &v1.GetDiffEntriesResponse{JSON200: &entries}, nil
+}
+
+func (m *mockDeleteClient) DeleteEntryWithResponse(ctx context.Context, bucket, entryID string, reqEditors ...v1.RequestEditorFn) (*v1.DeleteEntryResponse, error) {
+	m.mu.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+
+	// Give other goroutines a chance to overlap with this one.
+	time.Sleep(20 * time.Millisecond)
+
+	m.mu.Lock()
+	m.inFlight--
+	m.deleted++
+	m.mu.Unlock()
+
+	return &v1.DeleteEntryResponse{
+		HTTPResponse: &http.Response{StatusCode: http.StatusNoContent},
+	}, nil
+}
+
+func TestDriverDeleteBoundedConcurrency(t *testing.T) {
+	const (
+		maxConcurrentDeletes = 3
+		numEntries           = 12
+	)
+
+	entries := make([]v1.Releaseentry, numEntries)
+	ids := make([]string, numEntries)
+	for i := range entries {
+		ids[i] = fmt.Sprintf("entry-%d", i)
+		entries[i] = v1.Releaseentry{Entryid: &ids[i]}
+	}
+
+	mock := &mockDeleteClient{entries: entries}
+
+	d := &driver{
+		client:               mock,
+		maxConcurrentDeletes: maxConcurrentDeletes,
+		listPageSize:         100,
+	}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	if err := d.Delete(ctx, "/some/path"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	if mock.deleted != numEntries {
+		t.Fatalf("expected %d deletes, got %d", numEntries, mock.deleted)
+	}
+	if mock.maxInFlight > maxConcurrentDeletes {
+		t.Fatalf("exceeded configured concurrency: observed %d, want <= %d", mock.maxInFlight, maxConcurrentDeletes)
+	}
+	if mock.maxInFlight < 2 {
+		t.Fatalf("expected deletes to run concurrently, observed max in-flight %d", mock.maxInFlight)
+	}
+}
@@ -0,0 +1,149 @@
+package ccd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	v1 "github.com/distribution/distribution/v3/registry/storage/driver/ccd/client/v1"
+)
+
+// mockPagingClient serves a fixed set of pages from GetDiffEntriesWithResponse,
+// recording the page/per-page values it was asked for.
+type mockPagingClient struct {
+	v1.ClientWithResponsesInterface
+
+	pages       [][]v1.Releaseentry
+	pagesSeen   []int
+	perPageSeen []int
+}
+
+func (m *mockPagingClient) GetDiffEntriesWithResponse(ctx context.Context, bucket string, params *v1.GetDiffEntriesParams, reqEditors ...v1.RequestEditorFn) (*v1.GetDiffEntriesResponse, error) {
+	m.pagesSeen = append(m.pagesSeen, *params.Page)
+	m.perPageSeen = append(m.perPageSeen, *params.PerPage)
+
+	idx := *params.Page - 1
+	if idx < 0 || idx >= len(m.pages) {
+		empty := []v1.Releaseentry{}
+		return &v1.GetDiffEntriesResponse{JSON200: &empty}, nil
+	}
+
+	entries := m.pages[idx]
+	return &v1.GetDiffEntriesResponse{JSON200: &entries}, nil
+}
+
+func TestGetEntriesMultiPage(t *testing.T) {
+	const perPage = 2
+
+	var pages [][]v1.Releaseentry
+	var want []string
+	for p := 0; p < 3; p++ {
+		var page []v1.Releaseentry
+		for i := 0; i < perPage; i++ {
+			path := fmt.Sprintf("/foo/%d-%d", p, i)
+			page = append(page, v1.Releaseentry{Path: &path})
+			want = append(want, path)
+		}
+		pages = append(pages, page)
+	}
+
+	mock := &mockPagingClient{pages: pages}
+
+	entries, err := getEntries(context.Background(), mock, "bucket", "/foo", perPage)
+	if err != nil {
+		t.Fatalf("getEntries: %v", err)
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(entries))
+	}
+	for i, e := range entries {
+		if *e.Path != want[i] {
+			t.Fatalf("entry %d: expected path %q, got %q", i, want[i], *e.Path)
+		}
+	}
+
+	// 3 pages of data, plus the empty page that ends pagination.
+	if len(mock.pagesSeen) != 4 {
+		t.Fatalf("expected 4 requests (3 data pages + terminator), got %d", len(mock.pagesSeen))
+	}
+	for _, pp := range mock.perPageSeen {
+		if pp != perPage {
+			t.Fatalf("expected every request to use PerPage %d, got %d", perPage, pp)
+		}
+	}
+}
+
+// mockWalkClient backs a driver for Walk tests: it returns a fixed set of
+// direct-descendant entries for List, and per-entry metadata for Stat.
+type mockWalkClient struct {
+	v1.ClientWithResponsesInterface
+
+	entries []v1.Releaseentry
+	served  bool
+}
+
+func (m *mockWalkClient) GetDiffEntriesWithResponse(ctx context.Context, bucket string, params *v1.GetDiffEntriesParams, reqEditors ...v1.RequestEditorFn) (*v1.GetDiffEntriesResponse, error) {
+	if m.served {
+		empty := []v1.Releaseentry{}
+		return &v1.GetDiffEntriesResponse{JSON200: &empty}, nil
+	}
+	m.served = true
+
+	entries := m.entries
+	return &v1.GetDiffEntriesResponse{JSON200: &entries}, nil
+}
+
+func (m *mockWalkClient) GetEntryByPathWithResponse(ctx context.Context, bucket string, params *v1.GetEntryByPathParams, reqEditors ...v1.RequestEditorFn) (*v1.GetEntryByPathResponse, error) {
+	for _, e := range m.entries {
+		if *e.Path == params.Path {
+			size := 0
+			return &v1.GetEntryByPathResponse{
+				JSON200: &v1.Entry{
+					Entryid:      e.Entryid,
+					ContentSize:  &size,
+					LastModified: e.LastModified,
+				},
+			}, nil
+		}
+	}
+
+	return &v1.GetEntryByPathResponse{JSON404: &v1.Error{}}, nil
+}
+
+// TestDriverWalkStopsOnSkipDirForFile exercises storagedriver.WalkFallback
+// (which Walk delegates to) against a flat CCD listing. Per Walk's own doc
+// comment, ErrSkipDir returned for a normal file (not a directory) halts the
+// traversal rather than merely skipping it.
+func TestDriverWalkStopsOnSkipDirForFile(t *testing.T) {
+	paths := []string{"/foo/a", "/foo/b", "/foo/c"}
+	modTime := time.Now()
+
+	var entries []v1.Releaseentry
+	for _, p := range paths {
+		p := p
+		id := p
+		entries = append(entries, v1.Releaseentry{Path: &p, Entryid: &id, LastModified: &modTime})
+	}
+
+	mock := &mockWalkClient{entries: entries}
+	d := &driver{client: mock, listPageSize: 100}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	var visited []string
+	err := d.Walk(ctx, "/foo", func(fi storagedriver.FileInfo) error {
+		visited = append(visited, fi.Path())
+		return storagedriver.ErrSkipDir
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	if len(visited) != 1 {
+		t.Fatalf("expected walk to stop after the first file, got %d calls: %v", len(visited), visited)
+	}
+}
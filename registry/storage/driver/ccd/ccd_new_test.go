@@ -0,0 +1,72 @@
+package ccd
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// validParams returns the minimal set of parameters NewWithClient needs to
+// succeed, for tests that only care about the httpclient/httpClient plumbing.
+func validParams() map[string]interface{} {
+	return map[string]interface{}{
+		"apikey":      "key",
+		"environment": "stage",
+	}
+}
+
+func TestNewWithClientHTTPClientParameterTakesPrecedence(t *testing.T) {
+	argClient := &http.Client{Timeout: time.Second}
+	paramClient := &http.Client{Timeout: 2 * time.Second}
+
+	params := validParams()
+	params["httpclient"] = paramClient
+
+	d, err := NewWithClient(params, argClient)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	drv, ok := d.StorageDriver.(*driver)
+	if !ok {
+		t.Fatalf("expected *driver, got %T", d.StorageDriver)
+	}
+	if drv.httpClient != paramClient {
+		t.Fatal("expected the httpclient parameter to take precedence over the function argument")
+	}
+}
+
+func TestNewWithClientUsesArgumentWhenNoParameterGiven(t *testing.T) {
+	argClient := &http.Client{Timeout: time.Second}
+
+	d, err := NewWithClient(validParams(), argClient)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	drv := d.StorageDriver.(*driver)
+	if drv.httpClient != argClient {
+		t.Fatal("expected the supplied *http.Client argument to be used")
+	}
+}
+
+func TestNewWithClientDefaultsToHTTPDefaultClient(t *testing.T) {
+	d, err := NewWithClient(validParams(), nil)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	drv := d.StorageDriver.(*driver)
+	if drv.httpClient != http.DefaultClient {
+		t.Fatal("expected http.DefaultClient to be used when no client is given at all")
+	}
+}
+
+func TestNewWithClientRejectsWrongHTTPClientParameterType(t *testing.T) {
+	params := validParams()
+	params["httpclient"] = "not-a-client"
+
+	if _, err := NewWithClient(params, nil); err == nil {
+		t.Fatal("expected an error for an httpclient parameter that isn't an *http.Client")
+	}
+}
@@ -1,58 +1,42 @@
 package ccd
 
 import (
-	"errors"
+	"context"
 	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
 )
 
-func TestBucketPath(t *testing.T) {
+func TestBucketIDFromContext(t *testing.T) {
 	tests := []struct {
 		name      string
-		path      string
+		ctx       context.Context
 		expBucket string
-		expError  error
+		expError  bool
 	}{
 		{
-			name:      "whole path",
-			path:      "/docker/registry/v2/repositories/foo/_uploads/bar/baz",
+			name:      "present",
+			ctx:       dcontext.WithValue(context.Background(), "vars.name", "foo"),
 			expBucket: "foo",
 		},
 		{
-			name:      "trailing slash",
-			path:      "/docker/registry/v2/repositories/foo/",
-			expBucket: "foo",
-		},
-		{
-			name:      "partial path",
-			path:      "/docker/registry/v2/repositories/foo",
-			expBucket: "foo",
-		},
-		{
-			name:     "missing bucket",
-			path:     "/docker/registry/v2/repositories/",
-			expError: errors.New(`could not parse bucket from path: "/docker/registry/v2/repositories/"`),
-		},
-		{
-			name:     "empty path",
-			path:     "",
-			expError: errors.New(`could not parse bucket from path: ""`),
+			name:     "missing",
+			ctx:      context.Background(),
+			expError: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			bucket, err := bucketFromPath(tt.path)
+			bucket, err := bucketIDFromContext(tt.ctx)
 			switch {
-			case tt.expError != nil:
-				if tt.expError.Error() != err.Error() {
-					t.Fatalf("expected error: %v got: %v", tt.expError, err)
+			case tt.expError:
+				if err == nil {
+					t.Fatal("expected error, got nil")
 				}
-				return
 			case err != nil:
-				t.Fatalf("uexpected error: %v", err)
-				return
-			case tt.expBucket != bucket:
+				t.Fatalf("unexpected error: %v", err)
+			case bucket != tt.expBucket:
 				t.Fatalf("expected bucket: %v got: %v", tt.expBucket, bucket)
-				return
 			}
 		})
 	}
@@ -0,0 +1,121 @@
+package ccd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	v1 "github.com/distribution/distribution/v3/registry/storage/driver/ccd/client/v1"
+)
+
+// mockURLForClient backs URLFor/bucketAccessToken tests: it serves a fixed
+// entry, and lets each test control whether acquiring a token or a content
+// link succeeds, while counting how many times a token was actually fetched.
+type mockURLForClient struct {
+	v1.ClientWithResponsesInterface
+
+	entryID string
+
+	tokenCalls   int
+	tokenErr     error
+	tokenJSON500 bool
+	linkErr      error
+}
+
+func (m *mockURLForClient) GetEntryByPathWithResponse(ctx context.Context, bucket string, params *v1.GetEntryByPathParams, reqEditors ...v1.RequestEditorFn) (*v1.GetEntryByPathResponse, error) {
+	return &v1.GetEntryByPathResponse{
+		JSON200: &v1.Entry{Entryid: &m.entryID},
+	}, nil
+}
+
+func (m *mockURLForClient) CreateBucketAccessTokenWithResponse(ctx context.Context, bucket string, body v1.CreateBucketAccessTokenJSONRequestBody, reqEditors ...v1.RequestEditorFn) (*v1.CreateBucketAccessTokenResponse, error) {
+	m.tokenCalls++
+	if m.tokenErr != nil {
+		return nil, m.tokenErr
+	}
+	if m.tokenJSON500 {
+		reason := "internal error"
+		return &v1.CreateBucketAccessTokenResponse{JSON500: &v1.Error{Reason: &reason}}, nil
+	}
+
+	token := "access-token"
+	return &v1.CreateBucketAccessTokenResponse{
+		JSON200: &v1.Accesstoken{Accesstoken: &token},
+	}, nil
+}
+
+func (m *mockURLForClient) GetContentLinkWithResponse(ctx context.Context, bucket, entryID string, params *v1.GetContentLinkParams, reqEditors ...v1.RequestEditorFn) (*v1.GetContentLinkResponse, error) {
+	if m.linkErr != nil {
+		return nil, m.linkErr
+	}
+
+	url := "https://cdn.example.com/signed"
+	return &v1.GetContentLinkResponse{
+		JSON200: &v1.Contentlink{Url: &url},
+	}, nil
+}
+
+func TestDriverURLForCachesBucketAccessToken(t *testing.T) {
+	mock := &mockURLForClient{entryID: "entry-1"}
+	d := &driver{client: mock, tokens: newTokenCache(time.Hour)}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	for i := 0; i < 3; i++ {
+		link, err := d.URLFor(ctx, "/foo", nil)
+		if err != nil {
+			t.Fatalf("URLFor: %v", err)
+		}
+		if link != "https://cdn.example.com/signed" {
+			t.Fatalf("unexpected link: %q", link)
+		}
+	}
+
+	if mock.tokenCalls != 1 {
+		t.Fatalf("expected the bucket access token to be fetched once and reused, got %d fetches", mock.tokenCalls)
+	}
+}
+
+func TestDriverURLForFallsBackWhenTokenUnavailable(t *testing.T) {
+	mock := &mockURLForClient{entryID: "entry-1", tokenErr: errors.New("token service unavailable")}
+	d := &driver{client: mock, tokens: newTokenCache(time.Hour)}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	_, err := d.URLFor(ctx, "/foo", nil)
+	if !errors.As(err, &storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected ErrUnsupportedMethod when a token can't be acquired, got %v", err)
+	}
+}
+
+// TestDriverURLForFallsBackOnBucketAccessTokenServerError covers the
+// CreateBucketAccessTokenWithResponse JSON500 case, which bucketAccessToken
+// must treat like every other CCD response handler in this package does
+// rather than silently falling through to "failed to determine access
+// token".
+func TestDriverURLForFallsBackOnBucketAccessTokenServerError(t *testing.T) {
+	mock := &mockURLForClient{entryID: "entry-1", tokenJSON500: true}
+	d := &driver{client: mock, tokens: newTokenCache(time.Hour)}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	_, err := d.URLFor(ctx, "/foo", nil)
+	if !errors.As(err, &storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected ErrUnsupportedMethod on a bucket access token server error, got %v", err)
+	}
+}
+
+func TestDriverURLForFallsBackWhenContentLinkUnavailable(t *testing.T) {
+	mock := &mockURLForClient{entryID: "entry-1", linkErr: errors.New("cdn unavailable")}
+	d := &driver{client: mock, tokens: newTokenCache(time.Hour)}
+
+	ctx := dcontext.WithValue(context.Background(), "vars.name", "bucket")
+
+	_, err := d.URLFor(ctx, "/foo", nil)
+	if !errors.As(err, &storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected ErrUnsupportedMethod when the content link can't be acquired, got %v", err)
+	}
+}
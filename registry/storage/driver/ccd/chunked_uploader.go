@@ -0,0 +1,199 @@
+package ccd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+const (
+	// defaultChunkSize is the amount of data buffered in memory before it is
+	// flushed to CCD as a single tus-style PATCH request.
+	defaultChunkSize = 8 * 1024 * 1024 // 8 MiB
+
+	// defaultMaxRetries is the number of times a chunk upload is retried
+	// before the write is considered failed.
+	defaultMaxRetries = 5
+
+	// defaultMaxConcurrent is the number of uploads, across all writers on a
+	// driver instance, that are allowed to be in-flight to CCD at once.
+	defaultMaxConcurrent = 10
+)
+
+// chunkedUploader drives a single tus-style resumable upload session against
+// a CCD entry's upload URL. Chunks are sent as HTTP PATCH requests carrying
+// Upload-Offset and, once the total size is known on the final chunk,
+// Upload-Length headers (Upload-Defer-Length otherwise). Transient failures
+// are retried with exponential backoff.
+type chunkedUploader struct {
+	ctx    context.Context
+	client *http.Client
+	apiKey string
+
+	uploadURL  string
+	chunkSize  int64
+	maxRetries int
+
+	// offset is the number of bytes CCD has acknowledged receiving so far.
+	offset int64
+}
+
+// newChunkedUploader returns a chunkedUploader ready to send chunks to
+// uploadURL, starting at offset. Requests are authenticated the same way as
+// every other call to CCD: HTTP basic auth with an empty username and apiKey
+// as the password.
+func newChunkedUploader(ctx context.Context, client *http.Client, apiKey, uploadURL string, offset, chunkSize int64, maxRetries int) *chunkedUploader {
+	return &chunkedUploader{
+		ctx:        ctx,
+		client:     client,
+		apiKey:     apiKey,
+		uploadURL:  uploadURL,
+		offset:     offset,
+		chunkSize:  chunkSize,
+		maxRetries: maxRetries,
+	}
+}
+
+// Offset probes CCD for the number of bytes it has received for this upload
+// session, allowing an interrupted upload to resume without resending
+// already-acknowledged bytes.
+func (u *chunkedUploader) Offset() (int64, error) {
+	req, err := http.NewRequestWithContext(u.ctx, http.MethodHead, u.uploadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth("", u.apiKey)
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("unexpected response probing upload offset: %v", res.Status)
+	}
+
+	offset, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse Upload-Offset header: %w", err)
+	}
+
+	return offset, nil
+}
+
+// WriteChunk uploads p as a single chunk starting at the uploader's current
+// offset, retrying transient errors with exponential backoff. final must be
+// true for the chunk that completes the upload, so the total length can be
+// communicated to CCD per the tus protocol.
+//
+// A failed attempt may still have been durably received by CCD even though
+// we never saw a successful response (e.g. a dropped connection after the
+// bytes were written server-side). So before resending, each retry re-probes
+// the server-reported Upload-Offset via HEAD and only resends whatever
+// portion of p CCD hasn't actually acknowledged, rather than blindly
+// replaying the whole chunk at the offset recorded before the failure.
+func (u *chunkedUploader) WriteChunk(p []byte, final bool) error {
+	remaining := p
+
+	var err error
+	for attempt := 0; attempt <= u.maxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-u.ctx.Done():
+				return u.ctx.Err()
+			}
+
+			if acked, perr := u.Offset(); perr == nil && acked > u.offset {
+				skip := acked - u.offset
+				if skip > int64(len(remaining)) {
+					skip = int64(len(remaining))
+				}
+				remaining = remaining[skip:]
+				u.offset = acked
+			}
+
+			dcontext.GetLoggerWithFields(u.ctx, map[interface{}]interface{}{
+				"attempt": attempt,
+				"error":   err,
+			}).Warn("ccd: retrying chunk upload")
+
+			if len(remaining) == 0 {
+				// CCD had already durably received every byte of this chunk;
+				// only the prior response was lost.
+				return nil
+			}
+		}
+
+		if err = u.putChunk(remaining, final); err == nil {
+			u.offset += int64(len(remaining))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("upload chunk after %d attempts: %w", u.maxRetries+1, err)
+}
+
+// putChunk sends a single tus-style PATCH. The total upload length is not
+// known until the final chunk, so every non-final PATCH declares
+// Upload-Defer-Length per the protocol, and the final one supplies the now-
+// known Upload-Length instead.
+func (u *chunkedUploader) putChunk(p []byte, final bool) error {
+	req, err := http.NewRequestWithContext(u.ctx, http.MethodPatch, u.uploadURL, bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", u.apiKey)
+	req.Header.Set("Content-Type", contentTypeOctetStream)
+	req.Header.Set("Content-Length", strconv.Itoa(len(p)))
+	req.Header.Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+
+	if final {
+		req.Header.Set("Upload-Length", strconv.FormatInt(u.offset+int64(len(p)), 10))
+	} else {
+		req.Header.Set("Upload-Defer-Length", "1")
+	}
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 5 {
+		return fmt.Errorf("server error: %v", res.Status)
+	} else if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected response: %v", res.Status)
+	}
+
+	return nil
+}
+
+// Abort cancels the upload session, freeing the partial upload CCD is
+// holding on our behalf.
+func (u *chunkedUploader) Abort() error {
+	req, err := http.NewRequestWithContext(u.ctx, http.MethodDelete, u.uploadURL, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", u.apiKey)
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 5 {
+		return fmt.Errorf("server error: %v", res.Status)
+	}
+
+	return nil
+}
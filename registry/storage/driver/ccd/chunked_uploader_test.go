@@ -0,0 +1,180 @@
+package ccd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// tusTestServer is a minimal tus-style upload endpoint used to exercise
+// chunkedUploader against real HTTP semantics: HEAD reports the current
+// Upload-Offset, PATCH appends to the stored data (rejecting a mismatched
+// offset), and DELETE aborts the session.
+type tusTestServer struct {
+	mu   sync.Mutex
+	data []byte
+
+	// length is the Upload-Length the client most recently declared on a
+	// final chunk, or -1 if none has been seen yet.
+	length int64
+
+	aborted bool
+
+	// failNextPatch, if true, makes the next PATCH store the bytes (as CCD
+	// would durably do) but respond with a 500, simulating a response lost
+	// in transit.
+	failNextPatch bool
+}
+
+// testAPIKey is the CCD API key used across this package's tests. The test
+// servers don't assert on it, but requests should still carry it the same
+// way production requests would.
+const testAPIKey = "test-api-key"
+
+func newTusTestServer() (*tusTestServer, *httptest.Server) {
+	s := &tusTestServer{length: -1}
+	return s, httptest.NewServer(http.HandlerFunc(s.handle))
+}
+
+func (s *tusTestServer) snapshot() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]byte, len(s.data))
+	copy(out, s.data)
+	return out
+}
+
+func (s *tusTestServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset != int64(len(s.data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.data = append(s.data, buf.Bytes()...)
+
+		if ul := r.Header.Get("Upload-Length"); ul != "" {
+			n, err := strconv.ParseInt(ul, 10, 64)
+			if err == nil {
+				s.length = n
+			}
+		}
+
+		fail := s.failNextPatch
+		s.failNextPatch = false
+
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		s.aborted = true
+		s.data = nil
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestChunkedUploaderWriteChunk(t *testing.T) {
+	srv, ts := newTusTestServer()
+	defer ts.Close()
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+
+	u := newChunkedUploader(context.Background(), ts.Client(), testAPIKey, ts.URL, 0, 8, 3)
+
+	const chunkSize = 8
+	for i := 0; i < len(content); i += chunkSize {
+		end := i + chunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+
+		if err := u.WriteChunk(content[i:end], end == len(content)); err != nil {
+			t.Fatalf("write chunk: %v", err)
+		}
+	}
+
+	if !bytes.Equal(srv.snapshot(), content) {
+		t.Fatalf("expected server to hold %q, got %q", content, srv.snapshot())
+	}
+	if srv.length != int64(len(content)) {
+		t.Fatalf("expected Upload-Length %d, got %d", len(content), srv.length)
+	}
+}
+
+func TestChunkedUploaderOffset(t *testing.T) {
+	srv, ts := newTusTestServer()
+	defer ts.Close()
+	srv.data = []byte("hello")
+
+	u := newChunkedUploader(context.Background(), ts.Client(), testAPIKey, ts.URL, 0, 100, 1)
+
+	offset, err := u.Offset()
+	if err != nil {
+		t.Fatalf("offset: %v", err)
+	}
+	if offset != 5 {
+		t.Fatalf("expected offset 5, got %d", offset)
+	}
+}
+
+func TestChunkedUploaderAbort(t *testing.T) {
+	srv, ts := newTusTestServer()
+	defer ts.Close()
+	srv.data = []byte("hello")
+
+	u := newChunkedUploader(context.Background(), ts.Client(), testAPIKey, ts.URL, 5, 100, 1)
+	if err := u.Abort(); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if !srv.aborted {
+		t.Fatal("expected server to observe an abort")
+	}
+}
+
+// TestChunkedUploaderRetryReprobesOffset simulates a PATCH whose bytes were
+// durably received by CCD but whose response was lost. WriteChunk must
+// re-probe the offset before retrying and must not resend (and therefore
+// duplicate) bytes CCD already has.
+func TestChunkedUploaderRetryReprobesOffset(t *testing.T) {
+	srv, ts := newTusTestServer()
+	defer ts.Close()
+	srv.failNextPatch = true
+
+	u := newChunkedUploader(context.Background(), ts.Client(), testAPIKey, ts.URL, 0, 100, 2)
+
+	if err := u.WriteChunk([]byte("hello"), true); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+
+	if got := srv.snapshot(); !bytes.Equal(got, []byte("hello")) {
+		t.Fatalf("expected no duplicated bytes, got %q", got)
+	}
+	if u.offset != 5 {
+		t.Fatalf("expected uploader offset 5, got %d", u.offset)
+	}
+}
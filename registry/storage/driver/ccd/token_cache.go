@@ -0,0 +1,54 @@
+package ccd
+
+import (
+	"sync"
+	"time"
+)
+
+// bucketToken is a cached CCD bucket access token, along with the time at
+// which it should be treated as expired and refreshed.
+type bucketToken struct {
+	value  string
+	expiry time.Time
+}
+
+// tokenCache caches per-bucket CCD access tokens so that URLFor does not
+// need to acquire a fresh one on every call. Tokens are refreshed once they
+// pass their configured TTL, which callers should set slightly shorter than
+// CCD's own token lifetime.
+type tokenCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	tokens map[string]bucketToken
+}
+
+// newTokenCache returns a tokenCache whose entries are refreshed after ttl.
+func newTokenCache(ttl time.Duration) *tokenCache {
+	return &tokenCache{
+		ttl:    ttl,
+		tokens: make(map[string]bucketToken),
+	}
+}
+
+// Get returns the cached token for bucket if it hasn't expired, otherwise it
+// calls fetch to acquire and cache a new one.
+func (c *tokenCache) Get(bucket string, fetch func() (string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.tokens[bucket]; ok && time.Now().Before(t.expiry) {
+		return t.value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return "", err
+	}
+
+	c.tokens[bucket] = bucketToken{
+		value:  value,
+		expiry: time.Now().Add(c.ttl),
+	}
+
+	return value, nil
+}
@@ -0,0 +1,97 @@
+package ccd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheCachesWithinTTL(t *testing.T) {
+	c := newTokenCache(time.Hour)
+
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return "token", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		token, err := c.Get("bucket", fetch)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if token != "token" {
+			t.Fatalf("expected token %q, got %q", "token", token)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected fetch to be called once, got %d", calls)
+	}
+}
+
+func TestTokenCacheRefreshesAfterTTL(t *testing.T) {
+	c := newTokenCache(time.Millisecond)
+
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return fmt.Sprintf("token-%d", calls), nil
+	}
+
+	first, err := c.Get("bucket", fetch)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	second, err := c.Get("bucket", fetch)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if first == second {
+		t.Fatalf("expected a refreshed token once the TTL elapsed, got %q both times", first)
+	}
+	if calls != 2 {
+		t.Fatalf("expected fetch to be called twice, got %d", calls)
+	}
+}
+
+func TestTokenCacheIsolatesBuckets(t *testing.T) {
+	c := newTokenCache(time.Hour)
+
+	a, err := c.Get("bucket-a", func() (string, error) { return "token-a", nil })
+	if err != nil {
+		t.Fatalf("get a: %v", err)
+	}
+	b, err := c.Get("bucket-b", func() (string, error) { return "token-b", nil })
+	if err != nil {
+		t.Fatalf("get b: %v", err)
+	}
+
+	if a == b {
+		t.Fatalf("expected distinct buckets to get distinct tokens, got %q for both", a)
+	}
+}
+
+func TestTokenCachePropagatesFetchError(t *testing.T) {
+	c := newTokenCache(time.Hour)
+
+	wantErr := errors.New("boom")
+	_, err := c.Get("bucket", func() (string, error) { return "", wantErr })
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected fetch error to propagate, got %v", err)
+	}
+
+	// A failed fetch must not poison the cache with an empty/invalid entry.
+	token, err := c.Get("bucket", func() (string, error) { return "token", nil })
+	if err != nil {
+		t.Fatalf("get after failed fetch: %v", err)
+	}
+	if token != "token" {
+		t.Fatalf("expected token %q, got %q", "token", token)
+	}
+}
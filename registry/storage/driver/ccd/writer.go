@@ -1,48 +1,179 @@
 package ccd
 
 import (
-	"bufio"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"hash"
+	"net/http"
+	"sync"
 
 	v1 "github.com/distribution/distribution/v3/registry/storage/driver/ccd/client/v1"
 )
 
-// writer is a storagedriver.FileWriter backed by Unity Cloud Content Delivery.
+// writer is a storagedriver.FileWriter backed by Unity Cloud Content
+// Delivery. Unlike the previous implementation it never spools content to
+// local disk: bytes are buffered only until a full chunk is available, then
+// streamed to CCD's tus-style upload endpoint.
 type writer struct {
 	ctx context.Context
 
-	// File
-	file *os.File
+	client     v1.ClientWithResponsesInterface
+	httpClient *http.Client
+	apiKey     string
+	sem        chan struct{}
+
+	bucket string
+	path   string
+
+	chunkSize  int64
+	maxRetries int
+
+	hash hash.Hash
 	size int64
-	bw   *bufio.Writer
+	buf  []byte
+
+	entryID   string
+	uploadURL string
+	uploader  *chunkedUploader
+
+	releaseOnce sync.Once
 
 	closed    bool
 	committed bool
 	cancelled bool
-
-	// CCD
-	client v1.ClientWithResponsesInterface
-	bucket string
-	path   string
 }
 
-// newWriter returns a new writer which will write content to file and then
-// upload to CCD on commit.
-func newWriter(ctx context.Context, file *os.File, client v1.ClientWithResponsesInterface, size int64, bucket, path string) *writer {
+// newWriter returns a new writer which streams content to a CCD upload
+// session in chunkSize pieces.
+func newWriter(ctx context.Context, client v1.ClientWithResponsesInterface, httpClient *http.Client, apiKey string, sem chan struct{}, bucket, path string, chunkSize int64, maxRetries int) *writer {
 	return &writer{
-		ctx:    ctx,
-		file:   file,
-		client: client,
-		size:   size,
-		bw:     bufio.NewWriter(file),
-		bucket: bucket,
-		path:   path,
+		ctx:        ctx,
+		client:     client,
+		httpClient: httpClient,
+		apiKey:     apiKey,
+		sem:        sem,
+		bucket:     bucket,
+		path:       path,
+		chunkSize:  chunkSize,
+		maxRetries: maxRetries,
+		hash:       md5.New(),
+		buf:        make([]byte, 0, chunkSize),
+	}
+}
+
+// ensureSession lazily creates the CCD entry and begins its upload session
+// on the first call to Write (or Commit, for an empty blob). It does nothing
+// once a session exists, including one acquired by resume for an append.
+func (w *writer) ensureSession() error {
+	if w.uploadURL != "" {
+		return nil
+	}
+
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+
+	// Placeholder hash/size: CCD will receive the real values in Commit once
+	// every byte has been streamed and hashed.
+	entryID, uploadURL, err := createOrUpdateEntry(w.ctx, w.client, w.bucket, w.path, "", 0)
+	if err != nil {
+		<-w.sem
+		return err
+	}
+	if uploadURL == "" {
+		<-w.sem
+		return errors.New("ccd: entry did not return an upload URL")
+	}
+
+	w.entryID = entryID
+	w.uploadURL = uploadURL
+	w.uploader = newChunkedUploader(w.ctx, w.httpClient, w.apiKey, uploadURL, 0, w.chunkSize, w.maxRetries)
+
+	return nil
+}
+
+// resume acquires the upload session for an existing, in-progress entry and
+// fast-forwards past the bytes CCD has already acknowledged.
+//
+// Because the registry's normal upload flow opens, writes to, and closes a
+// new Writer for each PATCH request it receives, the rolling hash built up
+// in a previous Writer instance is gone by the time resume runs. So that
+// Commit's final MD5 covers the whole blob rather than just the bytes
+// written in this session, resume re-reads and re-hashes everything CCD has
+// already accepted before any further bytes are written.
+func (w *writer) resume() error {
+	entry, err := getEntryByPath(w.ctx, w.client, w.bucket, w.path)
+	if err != nil {
+		return err
+	}
+	if entry.UploadUrl == nil {
+		return errors.New("ccd: no in-progress upload for path")
+	}
+
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+
+	w.entryID = *entry.Entryid
+	w.uploadURL = *entry.UploadUrl
+	w.uploader = newChunkedUploader(w.ctx, w.httpClient, w.apiKey, w.uploadURL, 0, w.chunkSize, w.maxRetries)
+
+	offset, err := w.uploader.Offset()
+	if err != nil {
+		<-w.sem
+		return fmt.Errorf("probe upload offset: %w", err)
+	}
+	w.uploader.offset = offset
+	w.size = offset
+
+	if offset > 0 {
+		if err := w.rehash(offset); err != nil {
+			<-w.sem
+			return fmt.Errorf("rehash uploaded bytes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rehash reads back the first n bytes CCD has already accepted for this
+// entry and feeds them into the rolling hash, seeding it to the same state
+// it would be in had they been written in this Writer instance.
+func (w *writer) rehash(n int64) error {
+	content, err := getEntryContent(w.ctx, w.client, w.bucket, w.entryID)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) < n {
+		return fmt.Errorf("uploaded content is %d bytes, expected at least %d", len(content), n)
 	}
+
+	_, err = w.hash.Write(content[:n])
+	return err
+}
+
+// release gives up the writer's slot in the driver's concurrent upload
+// semaphore, if one was acquired. It is safe to call more than once (only
+// the first call has any effect), since Close, Cancel and Commit may all
+// end up calling it on the same writer depending on how the caller drives
+// it.
+//
+// TODO(dr): A writer that is opened and then simply abandoned (neither
+// Close, Cancel nor Commit called) will leak its slot until the process
+// restarts.
+func (w *writer) release() {
+	w.releaseOnce.Do(func() {
+		if w.uploadURL != "" {
+			<-w.sem
+		}
+	})
 }
 
 // Write implements io.WriteCloser
@@ -54,9 +185,49 @@ func (w *writer) Write(p []byte) (int, error) {
 	} else if w.cancelled {
 		return 0, fmt.Errorf("already cancelled")
 	}
-	n, err := w.bw.Write(p)
-	w.size += int64(n)
-	return n, err
+
+	if err := w.ensureSession(); err != nil {
+		return 0, err
+	}
+
+	var written int
+	for len(p) > 0 {
+		free := int(w.chunkSize) - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+
+		w.buf = append(w.buf, p[:free]...)
+		w.hash.Write(p[:free])
+		w.size += int64(free)
+		written += free
+		p = p[free:]
+
+		if int64(len(w.buf)) == w.chunkSize {
+			if err := w.flushChunk(false); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// flushChunk uploads any buffered bytes as a single chunk. final must be
+// true only for the chunk that completes the upload (i.e. from Commit),
+// since CCD doesn't learn the total length until then; it is sent even if
+// no bytes are buffered, so CCD always gets an explicit Upload-Length.
+func (w *writer) flushChunk(final bool) error {
+	if len(w.buf) == 0 && !final {
+		return nil
+	}
+
+	if err := w.uploader.WriteChunk(w.buf, final); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+
+	return nil
 }
 
 // Size returns the number of bytes written to this FileWriter.
@@ -65,40 +236,43 @@ func (w *writer) Size() int64 {
 }
 
 // Close implements io.WriteCloser
+//
+// Close ends this Writer's session without finalizing the entry, which is
+// the normal outcome for every PATCH but the last one: the registry opens a
+// fresh Writer (via resume) for each chunk it sends, only calling Commit on
+// the one that completes the blob. So the semaphore slot acquired by
+// ensureSession/resume is given back here, not just on Commit/Cancel.
 func (w *writer) Close() error {
 	if w.closed {
 		return fmt.Errorf("already closed")
 	}
+	defer w.release()
 
-	if err := w.bw.Flush(); err != nil {
-		return err
+	if !w.cancelled && !w.committed {
+		if err := w.flushChunk(false); err != nil {
+			return fmt.Errorf("flush buffered bytes: %w", err)
+		}
 	}
 
-	if err := w.file.Sync(); err != nil {
-		return err
-	}
-
-	if err := w.file.Close(); err != nil {
-		return err
-	}
 	w.closed = true
 	return nil
 }
 
-// Cancel removes any written content from this FileWriter.
+// Cancel removes any written content from this FileWriter, aborting the CCD
+// upload session so the server frees the partial upload.
 func (w *writer) Cancel() error {
 	if w.closed {
 		return fmt.Errorf("already closed")
 	}
 
 	w.cancelled = true
-	w.file.Close()
+	defer w.release()
 
-	if err := os.Remove(w.file.Name()); err != nil && !os.IsNotExist(err) {
-		return err
+	if w.uploader == nil {
+		return nil
 	}
 
-	return nil
+	return w.uploader.Abort()
 }
 
 // Commit flushes all content written to this FileWriter and makes it
@@ -113,52 +287,25 @@ func (w *writer) Commit() error {
 		return fmt.Errorf("already cancelled")
 	}
 
-	if err := w.bw.Flush(); err != nil {
-		return fmt.Errorf("flush file: %w", err)
+	if err := w.ensureSession(); err != nil {
+		return fmt.Errorf("begin upload session: %w", err)
 	}
+	defer w.release()
 
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("sync file: %w", err)
+	if err := w.flushChunk(true); err != nil {
+		return fmt.Errorf("flush final chunk: %w", err)
 	}
 
-	fi, err := w.file.Stat()
-	if err != nil {
-		return fmt.Errorf("stat file: %w", err)
-	}
+	fileHash := hex.EncodeToString(w.hash.Sum(nil))
 
-	if _, err = w.file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("rewind for hash: %w", err)
-	}
-
-	h := md5.New()
-	if _, err = io.Copy(h, w.file); err != nil {
-		return fmt.Errorf("file hash: %w", err)
-	}
-
-	fileHash := hex.EncodeToString(h.Sum(nil))
-	size := int(fi.Size())
-
-	if _, err = w.file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("rewind for upload: %w", err)
-	}
-
-	// CCD uploads are a two stage process.
-
-	// First we create an "entry" to hold the content.
-	entryID, err := createOrUpdateEntry(
-		w.ctx, w.client, w.bucket, w.path, fileHash, size,
-	)
-	if err != nil {
-		return fmt.Errorf("create/update entry: %w", err)
-	}
-
-	// Then we upload the content.
-	if err = uploadContent(w.ctx, w.client, w.bucket, entryID, fileHash, w.file); err != nil {
-		return fmt.Errorf("upload content: %w", err)
+	// Now that every byte has been streamed and hashed, tell CCD the real
+	// size/hash of the entry so it can be served.
+	if _, _, err := createOrUpdateEntry(w.ctx, w.client, w.bucket, w.path, fileHash, int(w.size)); err != nil {
+		return fmt.Errorf("finalize entry: %w", err)
 	}
 
 	w.committed = true
+	w.closed = true
 
-	// Remove the temporary file.
-	return os.Remove(w.file.Name())
+	return nil
 }
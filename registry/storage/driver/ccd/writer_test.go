@@ -0,0 +1,119 @@
+package ccd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	v1 "github.com/distribution/distribution/v3/registry/storage/driver/ccd/client/v1"
+)
+
+// mockWriterClient is a v1.ClientWithResponsesInterface that backs just
+// enough of the entry/upload-session API for writer tests. Any method it
+// doesn't implement panics via the embedded nil interface, which is fine
+// since these tests only exercise entry creation, lookup and content reads.
+type mockWriterClient struct {
+	v1.ClientWithResponsesInterface
+
+	entryID   string
+	uploadURL string
+	content   func() []byte
+
+	finalHash string
+	finalSize int
+}
+
+func (m *mockWriterClient) CreateOrUpdateEntryByPathWithResponse(ctx context.Context, bucket string, params *v1.CreateOrUpdateEntryByPathParams, body v1.CreateOrUpdateEntryByPathJSONRequestBody, reqEditors ...v1.RequestEditorFn) (*v1.CreateOrUpdateEntryByPathResponse, error) {
+	if body.ContentSize > 0 {
+		m.finalHash = body.ContentHash
+		m.finalSize = body.ContentSize
+	}
+
+	return &v1.CreateOrUpdateEntryByPathResponse{
+		JSON200: &v1.Entry{
+			Entryid:   &m.entryID,
+			UploadUrl: &m.uploadURL,
+		},
+	}, nil
+}
+
+func (m *mockWriterClient) GetEntryByPathWithResponse(ctx context.Context, bucket string, params *v1.GetEntryByPathParams, reqEditors ...v1.RequestEditorFn) (*v1.GetEntryByPathResponse, error) {
+	return &v1.GetEntryByPathResponse{
+		JSON200: &v1.Entry{
+			Entryid:   &m.entryID,
+			UploadUrl: &m.uploadURL,
+		},
+	}, nil
+}
+
+func (m *mockWriterClient) GetContentWithResponse(ctx context.Context, bucket, entryID string, params *v1.GetContentParams, reqEditors ...v1.RequestEditorFn) (*v1.GetContentResponse, error) {
+	return &v1.GetContentResponse{Body: m.content()}, nil
+}
+
+// TestWriterResumeRehashesUploadedBytes simulates the registry's normal
+// upload flow: one Writer per PATCH request, each opened, written to, and
+// closed in turn, with a final Writer that resumes, writes the remainder,
+// and commits. Commit's final hash must cover the whole blob, not just the
+// bytes written by the last Writer instance.
+func TestWriterResumeRehashesUploadedBytes(t *testing.T) {
+	srv, ts := newTusTestServer()
+	defer ts.Close()
+
+	const bucket = "bucket"
+	const path = "/docker/registry/v2/blobs/sha256/ab/abcd/data"
+
+	mock := &mockWriterClient{
+		entryID:   "entry-1",
+		uploadURL: ts.URL,
+		content:   srv.snapshot,
+	}
+
+	full := []byte("the quick brown fox jumps over the lazy dog, twice over")
+	half := len(full) / 2
+
+	// Shared across both sessions, exactly like the driver shares d.sem
+	// across every Writer() call.
+	sem := make(chan struct{}, 1)
+
+	// First PATCH: write the first half and close without committing.
+	w1 := newWriter(context.Background(), mock, ts.Client(), testAPIKey, sem, bucket, path, 8, 1)
+	if _, err := w1.Write(full[:half]); err != nil {
+		t.Fatalf("write (session 1): %v", err)
+	}
+	if err := w1.Close(); err != nil {
+		t.Fatalf("close (session 1): %v", err)
+	}
+
+	// Second PATCH: a fresh Writer resumes the in-progress upload, writes
+	// the remainder, and commits.
+	w2 := newWriter(context.Background(), mock, ts.Client(), testAPIKey, sem, bucket, path, 8, 1)
+	if err := w2.resume(); err != nil {
+		t.Fatalf("resume (session 2): %v", err)
+	}
+	if _, err := w2.Write(full[half:]); err != nil {
+		t.Fatalf("write (session 2): %v", err)
+	}
+	if err := w2.Commit(); err != nil {
+		t.Fatalf("commit (session 2): %v", err)
+	}
+
+	expHash := md5.Sum(full)
+	if got := hex.EncodeToString(expHash[:]); mock.finalHash != got {
+		t.Fatalf("expected final hash %q, got %q", got, mock.finalHash)
+	}
+	if mock.finalSize != len(full) {
+		t.Fatalf("expected final size %d, got %d", len(full), mock.finalSize)
+	}
+	if !bytes.Equal(srv.snapshot(), full) {
+		t.Fatalf("expected server to hold %q, got %q", full, srv.snapshot())
+	}
+
+	// w1's Close must have given back its slot in the shared semaphore, or
+	// w2's resume would have blocked forever trying to acquire it (sem has
+	// capacity 1, same as a driver configured with maxconcurrent=1).
+	if len(sem) != 0 {
+		t.Fatalf("expected shared semaphore to be empty after both sessions, got %d held", len(sem))
+	}
+}